@@ -0,0 +1,153 @@
+package main
+
+// A small SOCKS5 client (RFC 1928, with the user/password auth of RFC 1929), only CONNECT is supported.
+// It's built in rather than using golang.org/x/net/proxy to keep the exporter's dependencies down.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newSocks5Dial returns a dial function that opens TCP connections through the SOCKS5 proxy at proxyAddr,
+// proxyAddr is either host:port or socks5://[user:password@]host:port. The timeout passed to the dial
+// function covers connecting to the proxy and the handshake, 0 means no timeout.
+func newSocks5Dial(proxyAddr string) (func(network, addr string, timeout time.Duration) (net.Conn, error), error) {
+	if !strings.Contains(proxyAddr, "://") {
+		proxyAddr = "socks5://" + proxyAddr
+	}
+	u, err := url.Parse(proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, err
+	}
+
+	return func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		if network != "tcp" {
+			// unix sockets are local, there's nothing to proxy
+			return net.DialTimeout(network, addr, timeout)
+		}
+
+		deadline := time.Now().Add(timeout)
+		conn, err := net.DialTimeout("tcp", u.Host, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if timeout > 0 {
+			conn.SetDeadline(deadline)
+		}
+		if err := socks5Connect(conn, u.User, addr); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("SOCKS5 proxy %s: %s", u.Host, err)
+		}
+		conn.SetDeadline(time.Time{})
+		return conn, nil
+	}, nil
+}
+
+// socks5Connect does the SOCKS5 handshake (RFC 1928 and RFC 1929) on conn and asks the proxy to CONNECT to addr
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", portStr)
+	}
+
+	methods := []byte{0x00}
+	if user != nil {
+		methods = append(methods, 0x02)
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("unexpected protocol version: %d", reply[0])
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if user == nil {
+			return errors.New("proxy asked for credentials but none were given")
+		}
+		pwd, _ := user.Password()
+		if len(user.Username()) > 255 || len(pwd) > 255 {
+			return errors.New("proxy user name and password can't be longer than 255 bytes")
+		}
+		req := append([]byte{0x01, byte(len(user.Username()))}, user.Username()...)
+		req = append(append(req, byte(len(pwd))), pwd...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("authentication failed")
+		}
+	default:
+		return errors.New("no acceptable authentication method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		// let the proxy resolve host names, the exporter might not be able to
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 0x01), ip4...)
+	} else {
+		req = append(append(req, 0x04), ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("CONNECT to %s failed with code %d", addr, header[1])
+	}
+
+	// skip the bound address and port the proxy replies with
+	skip := 0
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		skip = int(l[0]) + 2
+	default:
+		return fmt.Errorf("unexpected address type: %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
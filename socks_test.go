@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestSocksProxy(t *testing.T) {
+	for _, proxy := range []string{"http://localhost:1080", "localhost", "socks5://"} {
+		if _, err := NewRedisExporter("localhost:6379", Options{Namespace: "test", SocksProxy: proxy}); err == nil {
+			t.Errorf("proxy: %s, expected error", proxy)
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() err: %s", err)
+	}
+	defer l.Close()
+
+	// a SOCKS5 proxy that wants user/password auth and then answers +PONG itself instead of connecting anywhere
+	requested := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 512)
+		io.ReadFull(conn, buf[:2])
+		io.ReadFull(conn, buf[:buf[1]])
+		conn.Write([]byte{0x05, 0x02})
+
+		io.ReadFull(conn, buf[:2])
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		pwd := make([]byte, buf[0])
+		io.ReadFull(conn, pwd)
+		if string(user) != "user" || string(pwd) != "pwd" {
+			conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		conn.Write([]byte{0x01, 0x00})
+
+		io.ReadFull(conn, buf[:5])
+		host := make([]byte, buf[4])
+		io.ReadFull(conn, host)
+		io.ReadFull(conn, buf[:2])
+		requested <- fmt.Sprintf("%s:%d", host, int(buf[0])<<8|int(buf[1]))
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+			conn.Write([]byte("+PONG\r\n"))
+		}
+	}()
+
+	e, err := NewRedisExporter("redis://redis.internal:6380", Options{Namespace: "test", SocksProxy: "socks5://user:pwd@" + l.Addr().String(), ConnectionTimeouts: time.Second})
+	if err != nil {
+		t.Fatalf("NewRedisExporter() err: %s", err)
+	}
+	c, err := e.connectToRedis(e.options.ConnectionTimeouts)
+	if err != nil {
+		t.Fatalf("connectToRedis() err: %s", err)
+	}
+	defer c.Close()
+
+	if got := <-requested; got != "redis.internal:6380" {
+		t.Errorf("want CONNECT to redis.internal:6380, got: %s", got)
+	}
+	if reply, err := redis.String(c.Do("PING")); err != nil || reply != "PONG" {
+		t.Errorf("want PONG, got: %s err: %s", reply, err)
+	}
+}
+
+func TestSocks5Connect(t *testing.T) {
+	ok := []byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0}
+	for _, tst := range []struct {
+		name    string
+		addr    string
+		user    *url.Userinfo
+		replies [][]byte
+		wantReq []byte
+		wantErr string
+	}{
+		{
+			name:    "no auth, host name",
+			addr:    "redis.internal:6379",
+			replies: [][]byte{{0x05, 0x00}, ok},
+			wantReq: append(append([]byte{0x05, 0x01, 0x00, 0x03, 14}, "redis.internal"...), 0x18, 0xeb),
+		},
+		{
+			name:    "ipv4",
+			addr:    "10.0.0.1:6379",
+			replies: [][]byte{{0x05, 0x00}, ok},
+			wantReq: []byte{0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1, 0x18, 0xeb},
+		},
+		{
+			name:    "ipv6, bound to a host name",
+			addr:    "[::1]:6379",
+			replies: [][]byte{{0x05, 0x00}, append(append([]byte{0x05, 0x00, 0x00, 0x03, 5}, "proxy"...), 0, 0)},
+			wantReq: []byte{0x05, 0x01, 0x00, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x18, 0xeb},
+		},
+		{
+			name:    "user and password",
+			addr:    "10.0.0.1:6379",
+			user:    url.UserPassword("user", "pwd"),
+			replies: [][]byte{{0x05, 0x02}, {0x01, 0x00}, ok},
+			wantReq: []byte{0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1, 0x18, 0xeb},
+		},
+		{name: "invalid port", addr: "10.0.0.1:99999", wantErr: "invalid port"},
+		{name: "wrong version", addr: "10.0.0.1:6379", replies: [][]byte{{0x04, 0x00}}, wantErr: "unexpected protocol version: 4"},
+		{name: "no acceptable method", addr: "10.0.0.1:6379", replies: [][]byte{{0x05, 0xff}}, wantErr: "no acceptable authentication method"},
+		{name: "credentials missing", addr: "10.0.0.1:6379", replies: [][]byte{{0x05, 0x02}}, wantErr: "proxy asked for credentials"},
+		{
+			name:    "auth rejected",
+			addr:    "10.0.0.1:6379",
+			user:    url.UserPassword("user", "wrong"),
+			replies: [][]byte{{0x05, 0x02}, {0x01, 0x01}},
+			wantErr: "authentication failed",
+		},
+		{
+			name:    "connect refused",
+			addr:    "10.0.0.1:6379",
+			replies: [][]byte{{0x05, 0x00}, {0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0}},
+			wantErr: "CONNECT to 10.0.0.1:6379 failed with code 5",
+		},
+		{
+			name:    "unknown address type",
+			addr:    "10.0.0.1:6379",
+			replies: [][]byte{{0x05, 0x00}, {0x05, 0x00, 0x00, 0x09}},
+			wantErr: "unexpected address type: 9",
+		},
+		{name: "proxy hangs up", addr: "10.0.0.1:6379", replies: [][]byte{{0x05, 0x00}}, wantErr: "EOF"},
+	} {
+		client, proxy := net.Pipe()
+
+		// the proxy answers each message of the client with the next reply and hangs up when it runs out
+		var lastReq []byte
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer proxy.Close()
+			buf := make([]byte, 512)
+			for _, reply := range tst.replies {
+				n, err := proxy.Read(buf)
+				if err != nil {
+					return
+				}
+				lastReq = append([]byte{}, buf[:n]...)
+				proxy.Write(reply)
+			}
+			if n, err := proxy.Read(buf); err == nil {
+				lastReq = append([]byte{}, buf[:n]...)
+			}
+		}()
+
+		err := socks5Connect(client, tst.user, tst.addr)
+		client.Close()
+		<-done
+
+		if tst.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+				t.Errorf("%s - want err containing %q, got: %v", tst.name, tst.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s - socks5Connect() err: %s", tst.name, err)
+			continue
+		}
+		if !bytes.Equal(lastReq, tst.wantReq) {
+			t.Errorf("%s - want CONNECT request %v, got: %v", tst.name, tst.wantReq, lastReq)
+		}
+	}
+}
+
+func TestSocks5DialTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() err: %s", err)
+	}
+	defer l.Close()
+
+	// a proxy that accepts connections but never answers the handshake
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	dial, err := newSocks5Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("newSocks5Dial() err: %s", err)
+	}
+	start := time.Now()
+	if _, err := dial("tcp", "redis.internal:6379", 100*time.Millisecond); err == nil {
+		t.Errorf("expected the handshake to time out")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("the handshake should be bounded by the timeout, took: %s", took)
+	}
+}
+
+func TestSocksProxyRetries(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() err: %s", err)
+	}
+	defer l.Close()
+
+	// the proxy hangs up on the first connections right away and then stops answering, the
+	// handshake of the last attempt should only get what's left of the connection timeout
+	go func() {
+		var hanging []net.Conn
+		defer func() {
+			for _, conn := range hanging {
+				conn.Close()
+			}
+		}()
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if i < 3 {
+				conn.Close()
+				continue
+			}
+			hanging = append(hanging, conn)
+		}
+	}()
+
+	timeout := time.Second
+	e, err := NewRedisExporter("redis://redis.internal:6379", Options{Namespace: "test", SocksProxy: l.Addr().String(), ConnectionRetries: 10, ConnectionTimeouts: timeout})
+	if err != nil {
+		t.Fatalf("NewRedisExporter() err: %s", err)
+	}
+	start := time.Now()
+	if _, err := e.connectWithRetries(); err == nil {
+		t.Errorf("expected error connecting through a proxy that doesn't answer")
+	}
+	if took := time.Since(start); took > timeout+50*time.Millisecond {
+		t.Errorf("want retries through the proxy to take at most the connection timeout of %s, took: %s", timeout, took)
+	}
+}